@@ -0,0 +1,154 @@
+// Copyright (c) 2018 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dsl
+
+import (
+	"github.com/gogo/protobuf/proto"
+)
+
+// CommitFunc represents a callback that commits a RESYNC/data-change transaction
+// into whatever the test wants to treat as "the agent".
+type CommitFunc func(map[string]proto.Message) error
+
+// KeyVal pairs a key with the value last seen under it - returned by
+// Reply.Diff() to report what changed between two Send() calls.
+type KeyVal struct {
+	Key   string
+	Value proto.Message
+}
+
+// Reply is returned by Send()/SendAtomic(). On top of the plain commit error
+// it exposes the diff against the previously committed snapshot.
+type Reply struct {
+	Err error
+
+	added   []KeyVal
+	updated []KeyVal
+	removed []KeyVal
+}
+
+// ReceiveReply is a no-op for the mock - there is no asynchronous channel to
+// receive from, the reply is already fully formed by Send().
+func (r *Reply) ReceiveReply() error {
+	return r.Err
+}
+
+// Diff returns the added, updated and removed key-value pairs computed by the
+// Send()/SendAtomic() call that produced this Reply.
+func (r *Reply) Diff() (added, updated, removed []KeyVal) {
+	return r.added, r.updated, r.removed
+}
+
+// lastCommitSnapshot is the reserved snapshot id CommonMockDSL uses internally
+// to remember what was last successfully committed, distinct from any
+// snapshot ids a test takes explicitly via Snapshot().
+const lastCommitSnapshot = "\x00last-commit"
+
+// CommonMockDSL implements the functionality shared by the linux and vpp
+// RESYNC/data-change mock DSLs.
+type CommonMockDSL struct {
+	CommitFunc CommitFunc
+	Values     map[string]proto.Message
+
+	snapshots map[string]map[string]proto.Message
+}
+
+// NewCommonMockDSL is a constructor for CommonMockDSL.
+func NewCommonMockDSL(commitFunc CommitFunc) CommonMockDSL {
+	return CommonMockDSL{
+		CommitFunc: commitFunc,
+		Values:     make(map[string]proto.Message),
+		snapshots:  make(map[string]map[string]proto.Message),
+	}
+}
+
+// Snapshot checkpoints the current set of values under the given id, so that
+// a later Restore(id) can bring the DSL back to this point - typically used
+// by tests to checkpoint state before triggering a resync and restore it
+// afterwards.
+func (d *CommonMockDSL) Snapshot(id string) {
+	d.snapshots[id] = cloneValues(d.Values)
+}
+
+// Restore brings the DSL back to the state captured by Snapshot(id). It is a
+// no-op if no such snapshot was taken.
+func (d *CommonMockDSL) Restore(id string) {
+	snapshot, taken := d.snapshots[id]
+	if !taken {
+		return
+	}
+	d.Values = cloneValues(snapshot)
+}
+
+// Send commits the transaction into the mock DB and reports the diff against
+// the last committed snapshot.
+func (d *CommonMockDSL) Send() *Reply {
+	return d.send(false)
+}
+
+// SendAtomic is like Send, except that if CommitFunc returns an error, the
+// DSL's Values are rolled back to the last committed snapshot instead of
+// being left with the failed, partially-applied transaction.
+func (d *CommonMockDSL) SendAtomic() *Reply {
+	return d.send(true)
+}
+
+// send diffs the pending Values against the last committed snapshot, commits,
+// and - for the atomic variant - rolls back to that snapshot on failure.
+func (d *CommonMockDSL) send(atomic bool) *Reply {
+	previous := d.snapshots[lastCommitSnapshot]
+	added, updated, removed := diffValues(previous, d.Values)
+
+	err := d.CommitFunc(d.Values)
+	if err != nil && atomic {
+		d.Values = cloneValues(previous)
+		return &Reply{Err: err}
+	}
+
+	if err == nil {
+		d.snapshots[lastCommitSnapshot] = cloneValues(d.Values)
+	}
+	return &Reply{Err: err, added: added, updated: updated, removed: removed}
+}
+
+// diffValues compares the previously committed snapshot against the values
+// about to be sent.
+func diffValues(previous, next map[string]proto.Message) (added, updated, removed []KeyVal) {
+	for key, value := range next {
+		if prevValue, existed := previous[key]; !existed {
+			added = append(added, KeyVal{Key: key, Value: value})
+		} else if !proto.Equal(prevValue, value) {
+			updated = append(updated, KeyVal{Key: key, Value: value})
+		}
+	}
+	for key, value := range previous {
+		if _, stillPresent := next[key]; !stillPresent {
+			removed = append(removed, KeyVal{Key: key, Value: value})
+		}
+	}
+	return added, updated, removed
+}
+
+// cloneValues deep-copies every proto value so that a snapshot does not share
+// pointers with d.Values - otherwise a caller mutating a proto it already
+// handed to the DSL in place would silently corrupt an earlier snapshot, and
+// diffValues/proto.Equal would miss the resulting "change" entirely.
+func cloneValues(values map[string]proto.Message) map[string]proto.Message {
+	cloned := make(map[string]proto.Message, len(values))
+	for key, value := range values {
+		cloned[key] = proto.Clone(value)
+	}
+	return cloned
+}
@@ -48,6 +48,13 @@ func (d *MockDataResyncDSL) LinuxRoute(val *linux_l3.StaticRoute) linuxclient.Da
 	return d
 }
 
+// LinuxConntrack adds Linux netfilter conntrack entry to the mock RESYNC request.
+func (d *MockDataResyncDSL) LinuxConntrack(val *linux_l3.ConntrackEntry) linuxclient.DataResyncDSL {
+	key := linux_l3.ConntrackEntryKey(val.Zone, val.SourceTuple, val.DestinationTuple)
+	d.Values[key] = val
+	return d
+}
+
 // VppInterface adds VPP interface to the mock RESYNC request.
 func (d *MockDataResyncDSL) VppInterface(val *interfaces.Interface) linuxclient.DataResyncDSL {
 	key := interfaces.InterfaceKey(val.Name)
@@ -200,6 +207,12 @@ func (d *MockDataResyncDSL) PuntToHost(val *punt.ToHost) linuxclient.DataResyncD
 
 // Send commits the transaction into the mock DB.
 func (d *MockDataResyncDSL) Send() vppclient.Reply {
-	err := d.CommitFunc(d.Values)
-	return &dsl.Reply{Err: err}
+	return d.CommonMockDSL.Send()
+}
+
+// SendAtomic is like Send, but rolls the pending Values back to the last
+// committed snapshot if CommitFunc returns an error, instead of leaving the
+// DSL holding a half-applied transaction.
+func (d *MockDataResyncDSL) SendAtomic() vppclient.Reply {
+	return d.CommonMockDSL.SendAtomic()
 }
@@ -0,0 +1,236 @@
+// Copyright (c) 2018 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package descriptor
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+
+	"github.com/ligato/cn-infra/logging"
+	scheduler "github.com/ligato/vpp-agent/plugins/kvscheduler/api"
+	"github.com/ligato/vpp-agent/plugins/linuxv2/l3plugin/descriptor/adapter"
+	l3linuxcalls "github.com/ligato/vpp-agent/plugins/linuxv2/l3plugin/linuxcalls"
+	l3 "github.com/ligato/vpp-agent/plugins/linuxv2/model/l3"
+)
+
+const (
+	// ConntrackDescriptorName is the name of the descriptor for Linux netfilter
+	// conntrack entries.
+	ConntrackDescriptorName = "linux-conntrack"
+)
+
+// A list of non-retriable errors:
+var (
+	// ErrConntrackWithoutTuple is returned when a conntrack entry is missing
+	// either its source or destination tuple.
+	ErrConntrackWithoutTuple = errors.New("Linux Conntrack entry defined without source/destination tuple")
+
+	// ErrConntrackWithInvalidTuple is returned when a conntrack tuple address
+	// cannot be parsed.
+	ErrConntrackWithInvalidTuple = errors.New("Linux Conntrack entry defined with invalid tuple address")
+)
+
+// ConntrackDescriptor teaches KVScheduler how to manage Linux netfilter
+// conntrack entries, so that NAT-translated connections survive pod restarts
+// (contiv-vpp policies rely on the conntrack table surviving an agent resync).
+type ConntrackDescriptor struct {
+	log       logging.Logger
+	l3Handler l3linuxcalls.NetlinkAPI
+
+	// zone is the netfilter conntrack zone this agent installs its own entries
+	// into. Dump uses it to tell agent-programmed flows apart from the rest of
+	// the host's conntrack table, which can (and on a busy node, will) contain
+	// many unrelated connections the agent has no business reporting.
+	zone uint32
+}
+
+// NewConntrackDescriptor creates a new instance of the Conntrack descriptor.
+// zone is the conntrack zone the agent uses for entries it manages - Dump only
+// reports flows tagged with this zone.
+func NewConntrackDescriptor(l3Handler l3linuxcalls.NetlinkAPI, zone uint32, log logging.PluginLogger) *ConntrackDescriptor {
+	return &ConntrackDescriptor{
+		l3Handler: l3Handler,
+		zone:      zone,
+		log:       log.NewLogger("conntrack-descriptor"),
+	}
+}
+
+// GetDescriptor returns descriptor suitable for registration (via adapter) with
+// the KVScheduler.
+func (d *ConntrackDescriptor) GetDescriptor() *adapter.ConntrackDescriptor {
+	return &adapter.ConntrackDescriptor{
+		Name:               ConntrackDescriptorName,
+		KeySelector:        d.IsConntrackKey,
+		ValueTypeName:      proto.MessageName(&l3.ConntrackEntry{}),
+		ValueComparator:    d.EquivalentConntrackEntries,
+		NBKeyPrefix:        l3.ConntrackEntryKeyPrefix,
+		Add:                d.Add,
+		Delete:             d.Delete,
+		IsRetriableFailure: d.IsRetriableFailure,
+		Dump:               d.Dump,
+	}
+}
+
+// IsConntrackKey returns <true> if the key identifies a Linux conntrack entry.
+func (d *ConntrackDescriptor) IsConntrackKey(key string) bool {
+	return strings.HasPrefix(key, l3.ConntrackEntryKeyPrefix)
+}
+
+// EquivalentConntrackEntries compares two conntrack entries by proto equality -
+// there is no case-insensitivity concern here, unlike addresses in other L3 keys.
+func (d *ConntrackDescriptor) EquivalentConntrackEntries(key string, oldEntry, newEntry *l3.ConntrackEntry) bool {
+	return proto.Equal(oldEntry, newEntry)
+}
+
+// Add creates a new conntrack entry.
+func (d *ConntrackDescriptor) Add(key string, entry *l3.ConntrackEntry) (metadata interface{}, err error) {
+	flow, err := d.toConntrackFlow(entry)
+	if err != nil {
+		d.log.Error(err)
+		return nil, err
+	}
+	if err := d.l3Handler.CreateConntrackEntry(flow); err != nil {
+		err = errors.Errorf("failed to create conntrack entry: %v", err)
+		d.log.Error(err)
+		return nil, err
+	}
+	return nil, nil
+}
+
+// Delete removes a conntrack entry, flushing the matching flow out of the table.
+func (d *ConntrackDescriptor) Delete(key string, entry *l3.ConntrackEntry, metadata interface{}) error {
+	flow, err := d.toConntrackFlow(entry)
+	if err != nil {
+		d.log.Error(err)
+		return err
+	}
+	if err := d.l3Handler.DeleteConntrackEntry(flow); err != nil {
+		err = errors.Errorf("failed to delete conntrack entry: %v", err)
+		d.log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// IsRetriableFailure returns <false> for errors related to invalid configuration.
+func (d *ConntrackDescriptor) IsRetriableFailure(err error) bool {
+	return err != ErrConntrackWithoutTuple && err != ErrConntrackWithInvalidTuple
+}
+
+// toConntrackFlow validates the entry and translates it into netlink's conntrack
+// flow representation.
+func (d *ConntrackDescriptor) toConntrackFlow(entry *l3.ConntrackEntry) (*netlink.ConntrackFlow, error) {
+	if entry.SourceTuple == nil || entry.DestinationTuple == nil {
+		return nil, ErrConntrackWithoutTuple
+	}
+
+	srcIP := net.ParseIP(entry.SourceTuple.IpAddress)
+	dstIP := net.ParseIP(entry.DestinationTuple.IpAddress)
+	if srcIP == nil || dstIP == nil {
+		return nil, ErrConntrackWithInvalidTuple
+	}
+
+	family := netlink.FAMILY_V4
+	if srcIP.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+
+	flow := &netlink.ConntrackFlow{
+		FamilyType: family,
+		Forward: netlink.IPTuple{
+			SrcIP:    srcIP,
+			DstIP:    dstIP,
+			Protocol: uint8(entry.Protocol),
+			SrcPort:  uint16(entry.SourceTuple.Port),
+			DstPort:  uint16(entry.DestinationTuple.Port),
+		},
+		Mark: entry.Mark,
+		Zone: uint16(entry.Zone),
+	}
+
+	if entry.NatTranslation != nil {
+		natIP := net.ParseIP(entry.NatTranslation.IpAddress)
+		if natIP == nil {
+			return nil, ErrConntrackWithInvalidTuple
+		}
+		flow.Reverse = netlink.IPTuple{
+			SrcIP:    dstIP,
+			DstIP:    natIP,
+			Protocol: uint8(entry.Protocol),
+			SrcPort:  uint16(entry.DestinationTuple.Port),
+			DstPort:  uint16(entry.NatTranslation.Port),
+		}
+	}
+
+	return flow, nil
+}
+
+// Dump returns the conntrack entries the agent is responsible for, i.e. the
+// flows tagged with its own conntrack zone - not the entire host conntrack
+// table, most of which belongs to connections the agent never configured.
+func (d *ConntrackDescriptor) Dump(correlate []adapter.ConntrackKVWithMetadata) ([]adapter.ConntrackKVWithMetadata, error) {
+	flows, err := d.l3Handler.ListConntrackEntries()
+	if err != nil {
+		d.log.Error(err)
+		return nil, err
+	}
+
+	var dump []adapter.ConntrackKVWithMetadata
+	for _, flow := range flows {
+		if uint32(flow.Zone) != d.zone {
+			continue
+		}
+		entry := &l3.ConntrackEntry{
+			Protocol: uint32(flow.Forward.Protocol),
+			Zone:     uint32(flow.Zone),
+			Mark:     flow.Mark,
+			SourceTuple: &l3.ConntrackEntry_Tuple{
+				IpAddress: flow.Forward.SrcIP.String(),
+				Port:      uint32(flow.Forward.SrcPort),
+			},
+			DestinationTuple: &l3.ConntrackEntry_Tuple{
+				IpAddress: flow.Forward.DstIP.String(),
+				Port:      uint32(flow.Forward.DstPort),
+			},
+			NatTranslation: natTranslationFromReverse(flow.Reverse),
+		}
+		dump = append(dump, adapter.ConntrackKVWithMetadata{
+			Key:    l3.ConntrackEntryKey(entry.Zone, entry.SourceTuple, entry.DestinationTuple),
+			Value:  entry,
+			Origin: scheduler.UnknownOrigin,
+		})
+	}
+	return dump, nil
+}
+
+// natTranslationFromReverse reconstructs the NatTranslation field from the
+// reverse tuple the kernel tracks for a NAT'd flow, mirroring the forward
+// translation toConntrackFlow applies when building Reverse from it - without
+// this, a dumped NAT entry never compares equal to its NB counterpart and gets
+// needlessly re-Added on every resync. A flow with no NAT applied reports a
+// zeroed-out reverse tuple (no destination IP), which is left as nil.
+func natTranslationFromReverse(reverse netlink.IPTuple) *l3.ConntrackEntry_Tuple {
+	if reverse.DstIP == nil {
+		return nil
+	}
+	return &l3.ConntrackEntry_Tuple{
+		IpAddress: reverse.DstIP.String(),
+		Port:      uint32(reverse.DstPort),
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2018 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package descriptor
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ligato/cn-infra/logging"
+	scheduler "github.com/ligato/vpp-agent/plugins/kvscheduler/api"
+	l3 "github.com/ligato/vpp-agent/plugins/linuxv2/model/l3"
+)
+
+const (
+	// defaultDNSResolveInterval is the re-resolve interval used when the agent
+	// is not configured with a specific one. The standard library resolver does
+	// not expose record TTLs, so this is a fixed interval rather than a
+	// TTL-derived one.
+	defaultDNSResolveInterval = 30 * time.Second
+
+	// minDNSResolveInterval is the shortest re-resolve interval accepted,
+	// protecting against an overly aggressive configuration hammering the
+	// system resolver.
+	minDNSResolveInterval = 5 * time.Second
+)
+
+// dnsTarget identifies which field of a route carried the unresolved
+// hostname/domain, i.e. where a resolved address has to be written back into
+// a sibling route.
+type dnsTarget int
+
+const (
+	dnsTargetDst dnsTarget = iota
+	dnsTargetGw
+)
+
+// dnsRouteState tracks what a single DNS-backed route (identified by its NB key)
+// has last resolved to, so that the resolver can diff answer sets between polls.
+type dnsRouteState struct {
+	route     *l3.StaticRoute
+	keepStale bool
+	resolved  map[string]*l3.StaticRoute // resolved IP -> sibling route derived from it
+	cancel    chan struct{}
+}
+
+// dnsResolver periodically re-resolves the hostname/domain carried by a route's
+// DstNetwork (or GwAddr) and keeps the set of derived, per-address sibling routes
+// in sync by prompting the KVScheduler to re-derive them via RouteDescriptor.DerivedValues.
+type dnsResolver struct {
+	log       logging.Logger
+	scheduler scheduler.KVScheduler
+
+	// resolveInterval is how often a registered route is re-resolved.
+	resolveInterval time.Duration
+
+	mu     sync.Mutex
+	routes map[string]*dnsRouteState // NB key -> state
+}
+
+// newDNSResolver creates a resolver that re-resolves every registered route
+// every resolveInterval. A non-positive resolveInterval falls back to
+// defaultDNSResolveInterval; anything shorter than minDNSResolveInterval is
+// clamped up to it.
+func newDNSResolver(scheduler scheduler.KVScheduler, log logging.Logger, resolveInterval time.Duration) *dnsResolver {
+	if resolveInterval <= 0 {
+		resolveInterval = defaultDNSResolveInterval
+	}
+	if resolveInterval < minDNSResolveInterval {
+		resolveInterval = minDNSResolveInterval
+	}
+	return &dnsResolver{
+		log:             log,
+		scheduler:       scheduler,
+		resolveInterval: resolveInterval,
+		routes:          make(map[string]*dnsRouteState),
+	}
+}
+
+// Register starts (or restarts, if already watched) periodic resolution for the
+// given route, whose DstNetwork/GwAddr was determined not to be a literal
+// CIDR/IP address.
+func (r *dnsResolver) Register(key string, route *l3.StaticRoute) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if state, watched := r.routes[key]; watched {
+		close(state.cancel)
+	}
+	state := &dnsRouteState{
+		route:     route,
+		keepStale: route.KeepStale,
+		resolved:  make(map[string]*l3.StaticRoute),
+		cancel:    make(chan struct{}),
+	}
+	r.routes[key] = state
+	go r.watch(key, state)
+}
+
+// Unregister stops re-resolving the given route. The sibling routes it had
+// derived are torn down implicitly - with the NB route gone, the scheduler
+// no longer calls DerivedValues for it at all.
+func (r *dnsResolver) Unregister(key string) {
+	r.mu.Lock()
+	state, watched := r.routes[key]
+	if !watched {
+		r.mu.Unlock()
+		return
+	}
+	delete(r.routes, key)
+	r.mu.Unlock()
+
+	close(state.cancel)
+}
+
+// Resolved returns the sibling route values currently known for the DNS-backed
+// route registered under key, indexed by the derived key each should be
+// exposed under. It is consumed by RouteDescriptor.DerivedValues.
+func (r *dnsResolver) Resolved(key string) map[string]*l3.StaticRoute {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state, watched := r.routes[key]
+	if !watched {
+		return nil
+	}
+	siblings := make(map[string]*l3.StaticRoute, len(state.resolved))
+	for ip, sibling := range state.resolved {
+		siblings[derivedDNSRouteKey(key, ip)] = sibling
+	}
+	return siblings
+}
+
+// watch runs in its own goroutine for the lifetime of a single DNS-backed route.
+func (r *dnsResolver) watch(key string, state *dnsRouteState) {
+	hostname, target := dnsHostname(state.route)
+	for {
+		r.resolveOnce(key, state, hostname, target)
+
+		select {
+		case <-state.cancel:
+			return
+		case <-time.After(r.resolveInterval):
+		}
+	}
+}
+
+// resolveOnce performs a single lookup and reconciles the sibling route set,
+// notifying the scheduler if anything changed. r.mu is held for the whole
+// reconciliation (not just the map mutations) because Resolved() - called
+// concurrently from the scheduler goroutine - both reads and iterates the
+// same state.resolved map; a bare mutation under the lock with an unguarded
+// iteration elsewhere is still a data race the Go runtime will panic on.
+func (r *dnsResolver) resolveOnce(key string, state *dnsRouteState, hostname string, target dnsTarget) {
+	addrs, err := net.LookupIP(hostname)
+	if err != nil {
+		r.log.WithFields(logging.Fields{
+			"hostname": hostname,
+			"err":      err,
+		}).Warn("Failed to resolve DNS route destination")
+		return
+	}
+
+	answers := make(map[string]struct{}, len(addrs))
+	for _, addr := range addrs {
+		answers[addr.String()] = struct{}{}
+	}
+
+	r.mu.Lock()
+
+	changed := false
+
+	// withdraw siblings that disappeared from the answer set, unless KeepStale
+	// was requested for this route
+	for ip := range state.resolved {
+		if _, stillPresent := answers[ip]; stillPresent {
+			continue
+		}
+		if state.keepStale {
+			continue
+		}
+		delete(state.resolved, ip)
+		changed = true
+	}
+
+	// install siblings for newly resolved addresses
+	for ip := range answers {
+		if _, already := state.resolved[ip]; already {
+			continue
+		}
+		state.resolved[ip] = dnsSibling(state.route, target, ip)
+		changed = true
+	}
+
+	r.mu.Unlock()
+
+	// notify outside the lock - PushSBNotification may synchronously call back
+	// into DerivedValues/Resolved, which also takes r.mu
+	if changed {
+		r.notify(key, state.route)
+	}
+}
+
+// notify asks the KVScheduler to re-derive the DNS-backed route's sibling set
+// (see RouteDescriptor.DerivedValues) after the background resolver updated
+// which addresses are currently live. The base route value is reported
+// unchanged - this merely prompts a refresh, it does not itself describe an
+// SB-observed value.
+func (r *dnsResolver) notify(key string, route *l3.StaticRoute) {
+	if err := r.scheduler.PushSBNotification(key, route, nil); err != nil {
+		r.log.WithField("key", key).Warn("Failed to push DNS route refresh notification: ", err)
+	}
+}
+
+// isLiteralDestination returns true if dst is already a CIDR/IP and therefore
+// does not need to go through the resolver.
+func isLiteralDestination(dst string) bool {
+	if _, _, err := net.ParseCIDR(dst); err == nil {
+		return true
+	}
+	return net.ParseIP(dst) != nil
+}
+
+// dnsHostname returns the hostname/domain that has to be resolved for the route
+// and which field it was taken from, preferring the destination network and
+// falling back to the gateway.
+func dnsHostname(route *l3.StaticRoute) (hostname string, target dnsTarget) {
+	if !isLiteralDestination(route.DstNetwork) {
+		return route.DstNetwork, dnsTargetDst
+	}
+	return route.GwAddr, dnsTargetGw
+}
+
+// dnsSibling builds the concrete route derived from a single resolved address,
+// writing it into whichever field (destination or gateway) originally carried
+// the unresolved hostname and leaving the other field as configured.
+func dnsSibling(route *l3.StaticRoute, target dnsTarget, ip string) *l3.StaticRoute {
+	sibling := *route
+	switch target {
+	case dnsTargetGw:
+		sibling.GwAddr = ip
+	default:
+		sibling.DstNetwork = withHostBits(ip)
+	}
+	return &sibling
+}
+
+// withHostBits turns a resolved IP into a /32 (or /128) destination network.
+func withHostBits(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed.To4() != nil {
+		return ip + "/32"
+	}
+	return ip + "/128"
+}
+
+// derivedDNSRouteKey builds the key under which a single resolved address of a
+// DNS-backed route is tracked as a derived value.
+func derivedDNSRouteKey(baseKey, resolvedIP string) string {
+	return baseKey + "/resolved/" + resolvedIP
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2018 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package descriptor
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+
+	"github.com/ligato/cn-infra/logging"
+	scheduler "github.com/ligato/vpp-agent/plugins/kvscheduler/api"
+	"github.com/ligato/vpp-agent/plugins/linuxv2/ifplugin"
+	ifdescriptor "github.com/ligato/vpp-agent/plugins/linuxv2/ifplugin/descriptor"
+	"github.com/ligato/vpp-agent/plugins/linuxv2/l3plugin/descriptor/adapter"
+	l3linuxcalls "github.com/ligato/vpp-agent/plugins/linuxv2/l3plugin/linuxcalls"
+	ifmodel "github.com/ligato/vpp-agent/plugins/linuxv2/model/interfaces"
+	l3 "github.com/ligato/vpp-agent/plugins/linuxv2/model/l3"
+	"github.com/ligato/vpp-agent/plugins/linuxv2/nsplugin"
+	nslinuxcalls "github.com/ligato/vpp-agent/plugins/linuxv2/nsplugin/linuxcalls"
+)
+
+const (
+	// NeighborDescriptorName is the name of the descriptor for Linux ARP/NDP
+	// neighbor entries.
+	NeighborDescriptorName = "linux-neighbor"
+
+	// dependency labels
+	neighborOutInterfaceDep = "interface"
+)
+
+// A list of non-retriable errors:
+var (
+	// ErrNeighborWithoutInterface is returned when a Linux neighbor is missing
+	// the outgoing interface reference.
+	ErrNeighborWithoutInterface = errors.New("Linux Neighbor defined without outgoing interface reference")
+
+	// ErrNeighborWithoutIPAddress is returned when a Linux neighbor is missing
+	// the IP address of the neighbor.
+	ErrNeighborWithoutIPAddress = errors.New("Linux Neighbor defined without IP address")
+
+	// ErrNeighborWithInvalidIPAddress is returned when a Linux neighbor IP address
+	// cannot be parsed.
+	ErrNeighborWithInvalidIPAddress = errors.New("Linux Neighbor defined with invalid IP address")
+
+	// ErrNeighborWithInvalidHWAddress is returned when a Linux neighbor hardware
+	// address cannot be parsed.
+	ErrNeighborWithInvalidHWAddress = errors.New("Linux Neighbor defined with invalid HW address")
+)
+
+// neighborStateToNetlink maps the NB neighbor state to the netlink NUD_* flag.
+var neighborStateToNetlink = map[l3.LinuxNeighbor_NeighborState]int{
+	l3.LinuxNeighbor_PERMANENT: netlink.NUD_PERMANENT,
+	l3.LinuxNeighbor_REACHABLE: netlink.NUD_REACHABLE,
+	l3.LinuxNeighbor_STALE:     netlink.NUD_STALE,
+}
+
+// netlinkStateToNeighbor is the inverse of neighborStateToNetlink, used by Dump.
+var netlinkStateToNeighbor = map[int]l3.LinuxNeighbor_NeighborState{
+	netlink.NUD_PERMANENT: l3.LinuxNeighbor_PERMANENT,
+	netlink.NUD_REACHABLE: l3.LinuxNeighbor_REACHABLE,
+	netlink.NUD_STALE:     l3.LinuxNeighbor_STALE,
+}
+
+// NeighborDescriptor teaches KVScheduler how to configure permanent/static
+// Linux L2 neighbor (ARP/NDP) entries, modelled on libnetwork's osl/neigh_linux.go.
+type NeighborDescriptor struct {
+	log       logging.Logger
+	l3Handler l3linuxcalls.NetlinkAPI
+	ifPlugin  ifplugin.API
+	nsPlugin  nsplugin.API
+}
+
+// NewNeighborDescriptor creates a new instance of the Neighbor descriptor.
+func NewNeighborDescriptor(ifPlugin ifplugin.API, nsPlugin nsplugin.API,
+	l3Handler l3linuxcalls.NetlinkAPI, log logging.PluginLogger) *NeighborDescriptor {
+
+	return &NeighborDescriptor{
+		l3Handler: l3Handler,
+		ifPlugin:  ifPlugin,
+		nsPlugin:  nsPlugin,
+		log:       log.NewLogger("neighbor-descriptor"),
+	}
+}
+
+// GetDescriptor returns descriptor suitable for registration (via adapter) with
+// the KVScheduler.
+func (d *NeighborDescriptor) GetDescriptor() *adapter.NeighborDescriptor {
+	return &adapter.NeighborDescriptor{
+		Name:               NeighborDescriptorName,
+		KeySelector:        d.IsNeighborKey,
+		ValueTypeName:      proto.MessageName(&l3.LinuxNeighbor{}),
+		ValueComparator:    d.EquivalentNeighbors,
+		NBKeyPrefix:        l3.NeighborKeyPrefix,
+		Add:                d.Add,
+		Delete:             d.Delete,
+		Modify:             d.Modify,
+		IsRetriableFailure: d.IsRetriableFailure,
+		Dependencies:       d.Dependencies,
+		Dump:               d.Dump,
+		DumpDependencies:   []string{ifdescriptor.InterfaceDescriptorName},
+	}
+}
+
+// IsNeighborKey returns <true> if the key identifies a Linux neighbor configuration.
+func (d *NeighborDescriptor) IsNeighborKey(key string) bool {
+	return strings.HasPrefix(key, l3.NeighborKeyPrefix)
+}
+
+// EquivalentNeighbors is case-insensitive comparison function for l3.LinuxNeighbor.
+func (d *NeighborDescriptor) EquivalentNeighbors(key string, oldNeigh, newNeigh *l3.LinuxNeighbor) bool {
+	if oldNeigh.OutgoingInterface != newNeigh.OutgoingInterface ||
+		oldNeigh.State != newNeigh.State ||
+		oldNeigh.Proxy != newNeigh.Proxy {
+		return false
+	}
+	return equalAddrs(oldNeigh.IpAddress, newNeigh.IpAddress) &&
+		strings.EqualFold(oldNeigh.HwAddress, newNeigh.HwAddress)
+}
+
+// Add adds a new Linux neighbor entry.
+func (d *NeighborDescriptor) Add(key string, neighbor *l3.LinuxNeighbor) (metadata interface{}, err error) {
+	err = d.updateNeighbor(neighbor, "add", d.l3Handler.AddNeighbor)
+	return nil, err
+}
+
+// Delete removes a Linux neighbor entry.
+func (d *NeighborDescriptor) Delete(key string, neighbor *l3.LinuxNeighbor, metadata interface{}) error {
+	return d.updateNeighbor(neighbor, "delete", d.l3Handler.DelNeighbor)
+}
+
+// Modify re-programs the neighbor entry - NeighSet is used for both add and modify.
+func (d *NeighborDescriptor) Modify(key string, oldNeighbor, newNeighbor *l3.LinuxNeighbor, oldMetadata interface{}) (newMetadata interface{}, err error) {
+	err = d.updateNeighbor(newNeighbor, "modify", d.l3Handler.AddNeighbor)
+	return nil, err
+}
+
+// IsRetriableFailure returns <false> for errors related to invalid configuration.
+func (d *NeighborDescriptor) IsRetriableFailure(err error) bool {
+	switch err {
+	case ErrNeighborWithoutInterface, ErrNeighborWithoutIPAddress,
+		ErrNeighborWithInvalidIPAddress, ErrNeighborWithInvalidHWAddress:
+		return false
+	}
+	return true
+}
+
+// updateNeighbor adds, modifies or deletes a Linux neighbor entry.
+func (d *NeighborDescriptor) updateNeighbor(neighbor *l3.LinuxNeighbor, actionName string, actionClb func(neigh *netlink.Neigh) error) error {
+	if neighbor.OutgoingInterface == "" {
+		d.log.Error(ErrNeighborWithoutInterface)
+		return ErrNeighborWithoutInterface
+	}
+	if neighbor.IpAddress == "" {
+		d.log.Error(ErrNeighborWithoutIPAddress)
+		return ErrNeighborWithoutIPAddress
+	}
+	ipAddr := net.ParseIP(neighbor.IpAddress)
+	if ipAddr == nil {
+		d.log.Error(ErrNeighborWithInvalidIPAddress)
+		return ErrNeighborWithInvalidIPAddress
+	}
+	var hwAddr net.HardwareAddr
+	if neighbor.HwAddress != "" {
+		var err error
+		hwAddr, err = net.ParseMAC(neighbor.HwAddress)
+		if err != nil {
+			d.log.Error(ErrNeighborWithInvalidHWAddress)
+			return ErrNeighborWithInvalidHWAddress
+		}
+	}
+
+	ifMeta, found := d.ifPlugin.GetInterfaceIndex().LookupByName(neighbor.OutgoingInterface)
+	if !found || ifMeta == nil {
+		err := errors.Errorf("failed to obtain metadata for interface %s", neighbor.OutgoingInterface)
+		d.log.Error(err)
+		return err
+	}
+
+	family := netlink.FAMILY_V4
+	if ipAddr.To4() == nil {
+		family = netlink.FAMILY_V6
+	}
+
+	netlinkNeigh := &netlink.Neigh{
+		LinkIndex:    ifMeta.LinuxIfIndex,
+		Family:       family,
+		State:        neighStateFor(neighbor),
+		IP:           ipAddr,
+		HardwareAddr: hwAddr,
+	}
+	if neighbor.Proxy {
+		netlinkNeigh.Flags |= netlink.NTF_PROXY
+	}
+
+	nsCtx := nslinuxcalls.NewNamespaceMgmtCtx()
+	revertNs, err := d.nsPlugin.SwitchToNamespace(nsCtx, ifMeta.Namespace)
+	if err != nil {
+		err = errors.Errorf("failed to switch namespace: %v", err)
+		d.log.Error(err)
+		return err
+	}
+	defer revertNs()
+
+	if err := actionClb(netlinkNeigh); err != nil {
+		err = errors.Errorf("failed to %s linux neighbor: %v", actionName, err)
+		d.log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// neighStateFor returns the netlink NUD_* flag for the neighbor, defaulting to
+// permanent - the only state that libnetwork's static neighbor entries use.
+func neighStateFor(neighbor *l3.LinuxNeighbor) int {
+	if state, known := neighborStateToNetlink[neighbor.State]; known {
+		return state
+	}
+	return netlink.NUD_PERMANENT
+}
+
+// Dependencies requires the outgoing interface to exist and be UP.
+func (d *NeighborDescriptor) Dependencies(key string, neighbor *l3.LinuxNeighbor) []scheduler.Dependency {
+	return []scheduler.Dependency{
+		{
+			Label: neighborOutInterfaceDep,
+			Key:   ifmodel.InterfaceStateKey(neighbor.OutgoingInterface, true),
+		},
+	}
+}
+
+// neighborDump is used as the return value sent via channel by dumpNeighbors().
+type neighborDump struct {
+	neighbors []adapter.NeighborKVWithMetadata
+	err       error
+}
+
+// Dump returns all neighbor entries associated with interfaces managed by this agent.
+func (d *NeighborDescriptor) Dump(correlate []adapter.NeighborKVWithMetadata) ([]adapter.NeighborKVWithMetadata, error) {
+	var dump []adapter.NeighborKVWithMetadata
+	ifMetaIdx := d.ifPlugin.GetInterfaceIndex()
+	nsCtx := nslinuxcalls.NewNamespaceMgmtCtx()
+
+	for _, ifName := range ifMetaIdx.ListAllInterfaces() {
+		ifMeta, found := ifMetaIdx.LookupByName(ifName)
+		if !found || ifMeta == nil {
+			continue
+		}
+
+		revertNs, err := d.nsPlugin.SwitchToNamespace(nsCtx, ifMeta.Namespace)
+		if err != nil {
+			// namespace and all the neighbors it had contained no longer exist
+			d.log.WithFields(logging.Fields{
+				"err":       err,
+				"namespace": ifMeta.Namespace,
+			}).Warn("Failed to dump namespace")
+			continue
+		}
+		neighs, err := d.l3Handler.GetNeighbors(ifMeta.LinuxIfIndex)
+		revertNs()
+		if err != nil {
+			d.log.Error(err)
+			return dump, err
+		}
+
+		for _, neigh := range neighs {
+			state, known := netlinkStateToNeighbor[neigh.State]
+			if !known {
+				// not a state configured by the agent (e.g. NUD_NOARP, NUD_INCOMPLETE)
+				continue
+			}
+			dump = append(dump, adapter.NeighborKVWithMetadata{
+				Key: l3.NeighborKey(ifName, neigh.IP.String()),
+				Value: &l3.LinuxNeighbor{
+					OutgoingInterface: ifName,
+					IpAddress:         neigh.IP.String(),
+					HwAddress:         neigh.HardwareAddr.String(),
+					State:             state,
+					Proxy:             neigh.Flags&netlink.NTF_PROXY != 0,
+				},
+				Origin: scheduler.UnknownOrigin,
+			})
+		}
+	}
+
+	return dump, nil
+}
@@ -16,13 +16,16 @@ package descriptor
 
 import (
 	"bytes"
+	"fmt"
 	"net"
 	"strings"
+	"time"
 
 	"github.com/gogo/protobuf/proto"
 	prototypes "github.com/gogo/protobuf/types"
 	"github.com/pkg/errors"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 
 	"github.com/ligato/cn-infra/logging"
 	"github.com/ligato/cn-infra/utils/addrs"
@@ -73,8 +76,36 @@ var (
 	// ErrRouteLinkWithGw is returned when link-local Linux route has gateway address
 	// specified - it shouldn't be since destination is already neighbour by definition.
 	ErrRouteLinkWithGw = errors.New("Link-local Linux Route was defined with non-empty GW address")
+
+	// ErrRouteWithInvalidNextHop is returned when a multi-path route contains a next hop
+	// with an invalid gateway address.
+	ErrRouteWithInvalidNextHop = errors.New("Linux Route defined with invalid next hop")
 )
 
+// routeTypeToNetlink maps NB route types that do not resolve via an outgoing
+// interface to their netlink/RTN_* equivalent.
+var routeTypeToNetlink = map[l3.StaticRoute_RouteType]int{
+	l3.StaticRoute_BLACKHOLE:   unix.RTN_BLACKHOLE,
+	l3.StaticRoute_UNREACHABLE: unix.RTN_UNREACHABLE,
+	l3.StaticRoute_PROHIBIT:    unix.RTN_PROHIBIT,
+	l3.StaticRoute_THROW:       unix.RTN_THROW,
+}
+
+// netlinkTypeToRoute is the inverse of routeTypeToNetlink, used by Dump.
+var netlinkTypeToRoute = map[int]l3.StaticRoute_RouteType{
+	unix.RTN_BLACKHOLE:   l3.StaticRoute_BLACKHOLE,
+	unix.RTN_UNREACHABLE: l3.StaticRoute_UNREACHABLE,
+	unix.RTN_PROHIBIT:    l3.StaticRoute_PROHIBIT,
+	unix.RTN_THROW:       l3.StaticRoute_THROW,
+}
+
+// isSpecialRouteType returns true for route types that do not require (and must
+// not carry) an outgoing interface/gateway, e.g. blackhole routes.
+func isSpecialRouteType(routeType l3.StaticRoute_RouteType) bool {
+	_, special := routeTypeToNetlink[routeType]
+	return special
+}
+
 // RouteDescriptor teaches KVScheduler how to configure Linux routes.
 type RouteDescriptor struct {
 	log       logging.Logger
@@ -85,20 +116,28 @@ type RouteDescriptor struct {
 
 	// parallelization of the Dump operation
 	dumpGoRoutinesCnt int
+
+	// resolves DNS-backed route destinations/gateways in the background
+	dnsResolver *dnsResolver
 }
 
-// NewRouteDescriptor creates a new instance of the Route descriptor.
+// NewRouteDescriptor creates a new instance of the Route descriptor. A
+// non-positive dnsResolveInterval makes DNS-backed routes re-resolve at
+// defaultDNSResolveInterval (see dns_resolver.go).
 func NewRouteDescriptor(
 	scheduler scheduler.KVScheduler, ifPlugin ifplugin.API, nsPlugin nsplugin.API,
-	l3Handler l3linuxcalls.NetlinkAPI, log logging.PluginLogger, dumpGoRoutinesCnt int) *RouteDescriptor {
+	l3Handler l3linuxcalls.NetlinkAPI, log logging.PluginLogger, dumpGoRoutinesCnt int,
+	dnsResolveInterval time.Duration) *RouteDescriptor {
 
+	logger := log.NewLogger("route-descriptor")
 	return &RouteDescriptor{
 		scheduler:         scheduler,
 		l3Handler:         l3Handler,
 		ifPlugin:          ifPlugin,
 		nsPlugin:          nsPlugin,
 		dumpGoRoutinesCnt: dumpGoRoutinesCnt,
-		log:               log.NewLogger("route-descriptor"),
+		dnsResolver:       newDNSResolver(scheduler, logger, dnsResolveInterval),
+		log:               logger,
 	}
 }
 
@@ -132,7 +171,8 @@ func (d *RouteDescriptor) EquivalentRoutes(key string, oldRoute, newRoute *l3.St
 	// attributes compared as usually:
 	if oldRoute.OutgoingInterface != newRoute.OutgoingInterface ||
 		oldRoute.Scope != newRoute.Scope ||
-		oldRoute.Metric != newRoute.Metric {
+		oldRoute.Metric != newRoute.Metric ||
+		oldRoute.Type != newRoute.Type {
 		return false
 	}
 
@@ -140,7 +180,52 @@ func (d *RouteDescriptor) EquivalentRoutes(key string, oldRoute, newRoute *l3.St
 	if !equalNetworks(oldRoute.DstNetwork, newRoute.DstNetwork) {
 		return false
 	}
-	return equalAddrs(getGwAddr(oldRoute), getGwAddr(newRoute))
+	if !equalAddrs(getGwAddr(oldRoute), getGwAddr(newRoute)) {
+		return false
+	}
+	return equivalentNextHops(oldRoute.NextHops, newRoute.NextHops)
+}
+
+// equivalentNextHops compares two sets of next hops order-independently - ECMP
+// routes are sent by the NB as a list, but netlink (and Dump) do not guarantee
+// any particular ordering of the MultiPath slice.
+func equivalentNextHops(oldHops, newHops []*l3.StaticRoute_NextHop) bool {
+	if len(oldHops) != len(newHops) {
+		return false
+	}
+	matched := make([]bool, len(newHops))
+	for _, oldHop := range oldHops {
+		found := false
+		for i, newHop := range newHops {
+			if matched[i] {
+				continue
+			}
+			if oldHop.OutgoingInterface == newHop.OutgoingInterface &&
+				normalizedWeight(oldHop) == normalizedWeight(newHop) &&
+				equalAddrs(oldHop.GwAddr, newHop.GwAddr) {
+				matched[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizedWeight returns the effective ECMP weight of a next hop the same way
+// toNextHopInfos programs it: Weight when set, otherwise the raw hop count + 1
+// (so an unset weight and hop count is netlink's implicit default of 1). This
+// makes NB configuration and a Dump round-trip (which always reconstructs an
+// explicit weight from netlink.NexthopInfo.Hops) compare as equivalent instead
+// of causing a perpetual re-Modify.
+func normalizedWeight(hop *l3.StaticRoute_NextHop) uint32 {
+	if hop.Weight > 0 {
+		return hop.Weight
+	}
+	return uint32(hop.Hops) + 1
 }
 
 var nonRetriableErrs = []error{
@@ -150,6 +235,7 @@ var nonRetriableErrs = []error{
 	ErrRouteWithInvalidDst,
 	ErrRouteWithInvalidGw,
 	ErrRouteLinkWithGw,
+	ErrRouteWithInvalidNextHop,
 }
 
 // IsRetriableFailure returns <false> for errors related to invalid configuration.
@@ -164,12 +250,22 @@ func (d *RouteDescriptor) IsRetriableFailure(err error) bool {
 
 // Add adds Linux route.
 func (d *RouteDescriptor) Add(key string, route *l3.StaticRoute) (metadata interface{}, err error) {
+	if !isLiteralDestination(route.DstNetwork) || !isLiteralDestination(getGwAddr(route)) {
+		// destination (or gateway) is a hostname/domain - hand it off to the DNS
+		// resolver, which programs one route per resolved address as it learns them
+		d.dnsResolver.Register(key, route)
+		return nil, nil
+	}
 	err = d.updateRoute(route, "add", d.l3Handler.AddStaticRoute)
 	return nil, err
 }
 
 // Delete removes Linux route.
 func (d *RouteDescriptor) Delete(key string, route *l3.StaticRoute, metadata interface{}) error {
+	if !isLiteralDestination(route.DstNetwork) || !isLiteralDestination(getGwAddr(route)) {
+		d.dnsResolver.Unregister(key)
+		return nil
+	}
 	return d.updateRoute(route, "delete", d.l3Handler.DelStaticRoute)
 }
 
@@ -182,9 +278,11 @@ func (d *RouteDescriptor) Modify(key string, oldRoute, newRoute *l3.StaticRoute,
 // updateRoute adds, modifies or deletes a Linux route.
 func (d *RouteDescriptor) updateRoute(route *l3.StaticRoute, actionName string, actionClb func(route *netlink.Route) error) error {
 	var err error
+	isMultiPath := len(route.NextHops) > 0
+	isSpecial := isSpecialRouteType(route.Type)
 
 	// validate the configuration first
-	if route.OutgoingInterface == "" {
+	if route.OutgoingInterface == "" && !isMultiPath && !isSpecial {
 		err = ErrRouteWithoutInterface
 		d.log.Error(err)
 		return err
@@ -203,16 +301,34 @@ func (d *RouteDescriptor) updateRoute(route *l3.StaticRoute, actionName string,
 	// Prepare Netlink Route object
 	netlinkRoute := &netlink.Route{}
 
-	// Get interface metadata
-	ifMeta, found := d.ifPlugin.GetInterfaceIndex().LookupByName(route.OutgoingInterface)
-	if !found || ifMeta == nil {
-		err = errors.Errorf("failed to obtain metadata for interface %s", route.OutgoingInterface)
-		d.log.Error(err)
-		return err
+	// route types such as blackhole/unreachable/prohibit/throw never reference
+	// an outgoing interface or gateway
+	if isSpecial {
+		netlinkRoute.Type = routeTypeToNetlink[route.Type]
 	}
 
-	// set link index
-	netlinkRoute.LinkIndex = ifMeta.LinuxIfIndex
+	// resolve the outgoing interface - for a single-path route it determines both
+	// the link index and the namespace the netlink call runs in; for ECMP routes
+	// each next hop carries its own link index (see toNextHopInfos below), and the
+	// namespace is that of the first next hop (all next hops of a Linux multi-path
+	// route share one namespace); interface-less route types stay namespace-agnostic
+	nsInterface := route.OutgoingInterface
+	if isMultiPath {
+		nsInterface = route.NextHops[0].OutgoingInterface
+	}
+	var ifMeta *ifdescriptor.LinuxInterfaceMetadata
+	if !isSpecial {
+		var found bool
+		ifMeta, found = d.ifPlugin.GetInterfaceIndex().LookupByName(nsInterface)
+		if !found || ifMeta == nil {
+			err = errors.Errorf("failed to obtain metadata for interface %s", nsInterface)
+			d.log.Error(err)
+			return err
+		}
+		if !isMultiPath {
+			netlinkRoute.LinkIndex = ifMeta.LinuxIfIndex
+		}
+	}
 
 	// set destination network
 	_, dstNet, err := net.ParseCIDR(route.DstNetwork)
@@ -234,6 +350,15 @@ func (d *RouteDescriptor) updateRoute(route *l3.StaticRoute, actionName string,
 		netlinkRoute.Gw = gwAddr
 	}
 
+	// set multi-path next hops, resolving the outgoing interface of each
+	if isMultiPath {
+		netlinkRoute.MultiPath, err = d.toNextHopInfos(route.NextHops)
+		if err != nil {
+			d.log.Error(err)
+			return err
+		}
+	}
+
 	// set route scope
 	scope, err := rtScopeFromNBToNetlink(route.Scope)
 	if err != nil {
@@ -245,15 +370,23 @@ func (d *RouteDescriptor) updateRoute(route *l3.StaticRoute, actionName string,
 	// set route metric
 	netlinkRoute.Priority = int(route.Metric)
 
-	// move to the namespace of the associated interface
+	// set routing table (VRF-like policy routing); 0 leaves the main table
+	if route.RouteTable != 0 {
+		netlinkRoute.Table = int(route.RouteTable)
+	}
+
+	// move to the namespace of the associated interface (not needed for the
+	// interface-less special route types, which are namespace-agnostic)
 	nsCtx := nslinuxcalls.NewNamespaceMgmtCtx()
-	revertNs, err := d.nsPlugin.SwitchToNamespace(nsCtx, ifMeta.Namespace)
-	if err != nil {
-		err = errors.Errorf("failed to switch namespace: %v", err)
-		d.log.Error(err)
-		return err
+	if ifMeta != nil {
+		revertNs, nsErr := d.nsPlugin.SwitchToNamespace(nsCtx, ifMeta.Namespace)
+		if nsErr != nil {
+			err = errors.Errorf("failed to switch namespace: %v", nsErr)
+			d.log.Error(err)
+			return err
+		}
+		defer revertNs()
 	}
-	defer revertNs()
 
 	// update route in the interface namespace
 	err = actionClb(netlinkRoute)
@@ -266,9 +399,58 @@ func (d *RouteDescriptor) updateRoute(route *l3.StaticRoute, actionName string,
 	return nil
 }
 
+// toNextHopInfos translates NB next hops into netlink's MultiPath representation,
+// resolving the outgoing interface of each hop against the interface index.
+func (d *RouteDescriptor) toNextHopInfos(nextHops []*l3.StaticRoute_NextHop) ([]*netlink.NexthopInfo, error) {
+	var infos []*netlink.NexthopInfo
+	for _, nextHop := range nextHops {
+		ifMeta, found := d.ifPlugin.GetInterfaceIndex().LookupByName(nextHop.OutgoingInterface)
+		if !found || ifMeta == nil {
+			return nil, errors.Errorf("failed to obtain metadata for interface %s", nextHop.OutgoingInterface)
+		}
+		info := &netlink.NexthopInfo{LinkIndex: ifMeta.LinuxIfIndex}
+		if nextHop.GwAddr != "" {
+			gwAddr := net.ParseIP(nextHop.GwAddr)
+			if gwAddr == nil {
+				return nil, ErrRouteWithInvalidNextHop
+			}
+			info.Gw = gwAddr
+		}
+		switch {
+		case nextHop.Weight > 0:
+			// Weight is authoritative when given; netlink expresses it as hop
+			// count, i.e. weight-1 (an unset weight is normalized to 1, see
+			// normalizedWeight, so this also covers Weight == 1 correctly).
+			info.Hops = int(nextHop.Weight) - 1
+		case nextHop.Hops > 0:
+			// no Weight given - fall back to the raw netlink hop count
+			info.Hops = int(nextHop.Hops)
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
 // Dependencies lists dependencies for a Linux route.
 func (d *RouteDescriptor) Dependencies(key string, route *l3.StaticRoute) []scheduler.Dependency {
 	var dependencies []scheduler.Dependency
+	if isSpecialRouteType(route.Type) {
+		// blackhole/unreachable/prohibit/throw routes have no interface or gateway
+		return dependencies
+	}
+	if len(route.NextHops) > 0 {
+		// ECMP route - wait for every listed interface and, where given, its gateway
+		for i, nextHop := range route.NextHops {
+			dependencies = append(dependencies, scheduler.Dependency{
+				Label: fmt.Sprintf("%s-%d", routeOutInterfaceDep, i),
+				Key:   ifmodel.InterfaceStateKey(nextHop.OutgoingInterface, true),
+			})
+			if gwAddr := net.ParseIP(nextHop.GwAddr); gwAddr != nil && !gwAddr.IsUnspecified() {
+				dependencies = append(dependencies, d.gwReachabilityDep(nextHop.OutgoingInterface, gwAddr, i))
+			}
+		}
+		return dependencies
+	}
 	// the outgoing interface must exist and be UP
 	if route.OutgoingInterface != "" {
 		dependencies = append(dependencies, scheduler.Dependency{
@@ -279,29 +461,44 @@ func (d *RouteDescriptor) Dependencies(key string, route *l3.StaticRoute) []sche
 	// GW must be routable
 	gwAddr := net.ParseIP(getGwAddr(route))
 	if gwAddr != nil && !gwAddr.IsUnspecified() {
-		dependencies = append(dependencies, scheduler.Dependency{
-			Label: routeGwReachabilityDep,
-			AnyOf: func(key string) bool {
-				dstAddr, ifName, isRouteKey := l3.ParseStaticLinkLocalRouteKey(key)
-				if isRouteKey && ifName == route.OutgoingInterface && dstAddr.Contains(gwAddr) {
-					// GW address is neighbour as told by another link-local route
-					return true
-				}
-				ifName, addr, isAddrKey := ifmodel.ParseInterfaceAddressKey(key)
-				if isAddrKey && ifName == route.OutgoingInterface && addr.Contains(gwAddr) {
-					// GW address is inside the local network of the outgoing interface
-					// as given by the assigned IP address
-					return true
-				}
-				return false
-			},
-		})
+		dependencies = append(dependencies, d.gwReachabilityDep(route.OutgoingInterface, gwAddr, 0))
 	}
 	return dependencies
 }
 
-// DerivedValues derives empty value under StaticLinkLocalRouteKey if route is link-local.
-// It is used in dependencies for network reachability of a route gateway (see above).
+// gwReachabilityDep builds the dependency that requires the given gateway address
+// to be reachable (as a neighbour) via the given outgoing interface.
+func (d *RouteDescriptor) gwReachabilityDep(outgoingInterface string, gwAddr net.IP, idx int) scheduler.Dependency {
+	return scheduler.Dependency{
+		Label: fmt.Sprintf("%s-%d", routeGwReachabilityDep, idx),
+		AnyOf: func(key string) bool {
+			dstAddr, ifName, isRouteKey := l3.ParseStaticLinkLocalRouteKey(key)
+			if isRouteKey && ifName == outgoingInterface && dstAddr.Contains(gwAddr) {
+				// GW address is neighbour as told by another link-local route
+				return true
+			}
+			ifName, addr, isAddrKey := ifmodel.ParseInterfaceAddressKey(key)
+			if isAddrKey && ifName == outgoingInterface && addr.Contains(gwAddr) {
+				// GW address is inside the local network of the outgoing interface
+				// as given by the assigned IP address
+				return true
+			}
+			neighIfName, neighIPAddr, isNeighKey := l3.ParseNeighborKey(key)
+			if isNeighKey && neighIfName == outgoingInterface && neighIPAddr == gwAddr.String() {
+				// GW address is reachable through a statically configured neighbor entry
+				return true
+			}
+			return false
+		},
+	}
+}
+
+// DerivedValues derives empty value under StaticLinkLocalRouteKey if route is link-local,
+// and - for a DNS-backed route - one sibling route per address the background
+// resolver currently has resolved (see dns_resolver.go). Deriving the siblings
+// here, rather than pushing them as SB facts, is what makes the scheduler
+// actually drive them through Add/Delete like any other route.
+// It is also used in dependencies for network reachability of a route gateway (see above).
 func (d *RouteDescriptor) DerivedValues(key string, route *l3.StaticRoute) (derValues []scheduler.KeyValuePair) {
 	if route.Scope == l3.StaticRoute_LINK {
 		derValues = append(derValues, scheduler.KeyValuePair{
@@ -309,6 +506,14 @@ func (d *RouteDescriptor) DerivedValues(key string, route *l3.StaticRoute) (derV
 			Value: &prototypes.Empty{},
 		})
 	}
+	if !isLiteralDestination(route.DstNetwork) || !isLiteralDestination(getGwAddr(route)) {
+		for siblingKey, sibling := range d.dnsResolver.Resolved(key) {
+			derValues = append(derValues, scheduler.KeyValuePair{
+				Key:   siblingKey,
+				Value: sibling,
+			})
+		}
+	}
 	return derValues
 }
 
@@ -380,7 +585,9 @@ func (d *RouteDescriptor) dumpRoutes(interfaces []string, goRoutineIdx, goRoutin
 			continue
 		}
 
-		// get routes assigned to this interface
+		// get routes assigned to this interface, across every routing table
+		// (GetStaticRoutes walks 0..RT_TABLE_MAX, not just the main table, so that
+		// policy-routed VRF-like tables are reflected in the dump as well)
 		v4Routes, v6Routes, err := d.l3Handler.GetStaticRoutes(ifMeta.LinuxIfIndex)
 		revertNs()
 		if err != nil {
@@ -413,15 +620,31 @@ func (d *RouteDescriptor) dumpRoutes(interfaces []string, goRoutineIdx, goRoutin
 				// route not configured by the agent
 				continue
 			}
+
+			nbRoute := &l3.StaticRoute{
+				OutgoingInterface: ifName,
+				Scope:             scope,
+				DstNetwork:        dstNet,
+				GwAddr:            gwAddr,
+				Metric:            uint32(route.Priority),
+				RouteTable:        uint32(route.Table),
+			}
+			if routeType, isSpecial := netlinkTypeToRoute[route.Type]; isSpecial {
+				nbRoute.Type = routeType
+				nbRoute.OutgoingInterface = ""
+			}
+			if len(route.MultiPath) > 0 {
+				nbRoute.OutgoingInterface = ""
+				nbRoute.NextHops = d.fromNextHopInfos(route.MultiPath)
+			}
+
 			dump.routes = append(dump.routes, adapter.RouteKVWithMetadata{
-				Key: l3.StaticRouteKey(dstNet, ifName),
-				Value: &l3.StaticRoute{
-					OutgoingInterface: ifName,
-					Scope:             scope,
-					DstNetwork:        dstNet,
-					GwAddr:            gwAddr,
-					Metric:            uint32(route.Priority),
-				},
+				// the table id must be part of the key - otherwise routes with
+				// the same destination/interface in different tables (policy
+				// routing) collide onto one key and non-default-table routes
+				// are mis-keyed as if they belonged to the main table
+				Key:    l3.StaticRouteTableKey(dstNet, ifName, nbRoute.RouteTable),
+				Value:  nbRoute,
 				Origin: scheduler.UnknownOrigin, // let the scheduler to determine the origin
 			})
 		}
@@ -430,6 +653,29 @@ func (d *RouteDescriptor) dumpRoutes(interfaces []string, goRoutineIdx, goRoutin
 	dumpCh <- dump
 }
 
+// fromNextHopInfos reconstructs the NB next hop list from a dumped netlink
+// multi-path route. The outgoing interface name of each hop is looked up from
+// the interface index; a hop whose link index is unknown is skipped.
+func (d *RouteDescriptor) fromNextHopInfos(multiPath []*netlink.NexthopInfo) []*l3.StaticRoute_NextHop {
+	var nextHops []*l3.StaticRoute_NextHop
+	for _, info := range multiPath {
+		ifName, _, found := d.ifPlugin.GetInterfaceIndex().LookupByLinuxIfIndex(info.LinkIndex)
+		if !found {
+			continue
+		}
+		var gwAddr string
+		if len(info.Gw) != 0 {
+			gwAddr = info.Gw.String()
+		}
+		nextHops = append(nextHops, &l3.StaticRoute_NextHop{
+			OutgoingInterface: ifName,
+			GwAddr:            gwAddr,
+			Weight:            uint32(info.Hops + 1),
+		})
+	}
+	return nextHops
+}
+
 // rtScopeFromNBToNetlink convert Route scope from NB configuration
 // to the corresponding Netlink constant.
 func rtScopeFromNBToNetlink(scope l3.StaticRoute_Scope) (netlink.Scope, error) {
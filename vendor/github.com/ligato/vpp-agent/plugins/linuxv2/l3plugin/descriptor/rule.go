@@ -0,0 +1,245 @@
+// Copyright (c) 2018 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package descriptor
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/ligato/cn-infra/logging"
+	scheduler "github.com/ligato/vpp-agent/plugins/kvscheduler/api"
+	"github.com/ligato/vpp-agent/plugins/linuxv2/l3plugin/descriptor/adapter"
+	l3linuxcalls "github.com/ligato/vpp-agent/plugins/linuxv2/l3plugin/linuxcalls"
+	l3 "github.com/ligato/vpp-agent/plugins/linuxv2/model/l3"
+)
+
+const (
+	// RuleDescriptorName is the name of the descriptor for Linux IP rules.
+	RuleDescriptorName = "linux-rule"
+
+	// dependency labels
+	ruleTableDep = "table-not-empty"
+)
+
+// A list of non-retriable errors:
+var (
+	// ErrRuleWithoutPriority is returned when a Linux rule is configured without priority.
+	ErrRuleWithoutPriority = errors.New("Linux Rule defined without priority")
+
+	// ErrRuleWithoutTable is returned when a Linux rule does not reference a routing table.
+	ErrRuleWithoutTable = errors.New("Linux Rule defined without a routing table")
+)
+
+// RuleDescriptor teaches KVScheduler how to configure Linux `ip rule` entries,
+// the selector half of policy routing (see RouteDescriptor for the per-table
+// routes they select among).
+type RuleDescriptor struct {
+	log       logging.Logger
+	l3Handler l3linuxcalls.NetlinkAPI
+}
+
+// NewRuleDescriptor creates a new instance of the Rule descriptor.
+func NewRuleDescriptor(l3Handler l3linuxcalls.NetlinkAPI, log logging.PluginLogger) *RuleDescriptor {
+	return &RuleDescriptor{
+		l3Handler: l3Handler,
+		log:       log.NewLogger("rule-descriptor"),
+	}
+}
+
+// GetDescriptor returns descriptor suitable for registration (via adapter) with
+// the KVScheduler.
+func (d *RuleDescriptor) GetDescriptor() *adapter.RuleDescriptor {
+	return &adapter.RuleDescriptor{
+		Name:               RuleDescriptorName,
+		KeySelector:        d.IsRuleKey,
+		ValueTypeName:      proto.MessageName(&l3.LinuxRule{}),
+		ValueComparator:    d.EquivalentRules,
+		NBKeyPrefix:        l3.RuleKeyPrefix,
+		Add:                d.Add,
+		Delete:             d.Delete,
+		Modify:             d.Modify,
+		IsRetriableFailure: d.IsRetriableFailure,
+		Dependencies:       d.Dependencies,
+		Dump:               d.Dump,
+	}
+}
+
+// IsRuleKey returns <true> if the key identifies a Linux rule configuration.
+func (d *RuleDescriptor) IsRuleKey(key string) bool {
+	return strings.HasPrefix(key, l3.RuleKeyPrefix)
+}
+
+// EquivalentRules compares two rules as netlink would see them, ignoring
+// formatting differences of the src/dst networks (case, host bits set within
+// the prefix); a network without a prefix length is compared as a plain string.
+func (d *RuleDescriptor) EquivalentRules(key string, oldRule, newRule *l3.LinuxRule) bool {
+	if oldRule.Priority != newRule.Priority ||
+		oldRule.Table != newRule.Table ||
+		oldRule.FwMark != newRule.FwMark ||
+		oldRule.InIface != newRule.InIface ||
+		oldRule.OutIface != newRule.OutIface ||
+		oldRule.Action != newRule.Action ||
+		oldRule.SuppressPrefixLen != newRule.SuppressPrefixLen {
+		return false
+	}
+	return equalNetworks(oldRule.SrcNetwork, newRule.SrcNetwork) &&
+		equalNetworks(oldRule.DstNetwork, newRule.DstNetwork)
+}
+
+// Add creates a new Linux `ip rule` entry.
+func (d *RuleDescriptor) Add(key string, rule *l3.LinuxRule) (metadata interface{}, err error) {
+	netlinkRule, err := d.toNetlinkRule(rule)
+	if err != nil {
+		d.log.Error(err)
+		return nil, err
+	}
+	if err := d.l3Handler.AddRule(netlinkRule); err != nil {
+		err = errors.Errorf("failed to add linux rule: %v", err)
+		d.log.Error(err)
+		return nil, err
+	}
+	return nil, nil
+}
+
+// Delete removes a Linux `ip rule` entry.
+func (d *RuleDescriptor) Delete(key string, rule *l3.LinuxRule, metadata interface{}) error {
+	netlinkRule, err := d.toNetlinkRule(rule)
+	if err != nil {
+		d.log.Error(err)
+		return err
+	}
+	if err := d.l3Handler.DelRule(netlinkRule); err != nil {
+		err = errors.Errorf("failed to delete linux rule: %v", err)
+		d.log.Error(err)
+		return err
+	}
+	return nil
+}
+
+// Modify re-creates the rule - `ip rule` entries have no stable handle to
+// modify in place, so Delete+Add is how the kernel itself expects changes.
+func (d *RuleDescriptor) Modify(key string, oldRule, newRule *l3.LinuxRule, oldMetadata interface{}) (newMetadata interface{}, err error) {
+	if err := d.Delete(key, oldRule, oldMetadata); err != nil {
+		return nil, err
+	}
+	return d.Add(key, newRule)
+}
+
+// IsRetriableFailure returns <false> for errors related to invalid configuration.
+func (d *RuleDescriptor) IsRetriableFailure(err error) bool {
+	return err != ErrRuleWithoutPriority && err != ErrRuleWithoutTable
+}
+
+// toNetlinkRule validates the rule and translates it into the netlink representation.
+func (d *RuleDescriptor) toNetlinkRule(rule *l3.LinuxRule) (*netlink.Rule, error) {
+	if rule.Priority == 0 {
+		return nil, ErrRuleWithoutPriority
+	}
+	if rule.Table == 0 {
+		return nil, ErrRuleWithoutTable
+	}
+
+	netlinkRule := netlink.NewRule()
+	netlinkRule.Priority = int(rule.Priority)
+	netlinkRule.Table = int(rule.Table)
+	netlinkRule.Mark = int(rule.FwMark)
+	netlinkRule.IifName = rule.InIface
+	netlinkRule.OifName = rule.OutIface
+	netlinkRule.SuppressPrefixlen = int(rule.SuppressPrefixLen)
+
+	if rule.SrcNetwork != "" {
+		_, srcNet, err := net.ParseCIDR(rule.SrcNetwork)
+		if err != nil {
+			return nil, errors.Errorf("rule defined with invalid source network %s", rule.SrcNetwork)
+		}
+		netlinkRule.Src = srcNet
+	}
+	if rule.DstNetwork != "" {
+		_, dstNet, err := net.ParseCIDR(rule.DstNetwork)
+		if err != nil {
+			return nil, errors.Errorf("rule defined with invalid destination network %s", rule.DstNetwork)
+		}
+		netlinkRule.Dst = dstNet
+	}
+
+	switch rule.Action {
+	case l3.LinuxRule_TO_TABLE:
+		// netlinkRule.Table already set above
+	case l3.LinuxRule_UNREACHABLE:
+		netlinkRule.Table = 0
+		netlinkRule.Type = unix.RTN_UNREACHABLE
+	}
+
+	return netlinkRule, nil
+}
+
+// Dependencies ensures that a rule pointing to a non-default table only gets
+// installed once that table has at least one route, so that traffic selected
+// by the rule is not blackholed while the table is still empty.
+func (d *RuleDescriptor) Dependencies(key string, rule *l3.LinuxRule) []scheduler.Dependency {
+	if rule.Table == 0 {
+		return nil
+	}
+	return []scheduler.Dependency{
+		{
+			Label: ruleTableDep,
+			AnyOf: func(key string) bool {
+				_, table, isRouteKey := l3.ParseStaticRouteTableKey(key)
+				return isRouteKey && table == rule.Table
+			},
+		},
+	}
+}
+
+// Dump returns all Linux `ip rule` entries currently installed.
+func (d *RuleDescriptor) Dump(correlate []adapter.RuleKVWithMetadata) ([]adapter.RuleKVWithMetadata, error) {
+	rules, err := d.l3Handler.ListRules()
+	if err != nil {
+		d.log.Error(err)
+		return nil, err
+	}
+
+	var dump []adapter.RuleKVWithMetadata
+	for _, rule := range rules {
+		var srcNet, dstNet string
+		if rule.Src != nil {
+			srcNet = rule.Src.String()
+		}
+		if rule.Dst != nil {
+			dstNet = rule.Dst.String()
+		}
+		nbRule := &l3.LinuxRule{
+			Priority:          uint32(rule.Priority),
+			Table:             uint32(rule.Table),
+			FwMark:            uint32(rule.Mark),
+			InIface:           rule.IifName,
+			OutIface:          rule.OifName,
+			SrcNetwork:        srcNet,
+			DstNetwork:        dstNet,
+			SuppressPrefixLen: int32(rule.SuppressPrefixlen),
+		}
+		dump = append(dump, adapter.RuleKVWithMetadata{
+			Key:    l3.RuleKey(nbRule.Priority, nbRule.Table),
+			Value:  nbRule,
+			Origin: scheduler.UnknownOrigin,
+		})
+	}
+	return dump, nil
+}
@@ -0,0 +1,284 @@
+// Copyright (c) 2018 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package descriptor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/ligato/cn-infra/logging"
+	"github.com/ligato/vpp-agent/plugins/linuxv2/ifplugin"
+	"github.com/ligato/vpp-agent/plugins/linuxv2/ifplugin/ifaceidx"
+	l3 "github.com/ligato/vpp-agent/plugins/linuxv2/model/l3"
+	"github.com/ligato/vpp-agent/plugins/linuxv2/nsplugin"
+	nslinuxcalls "github.com/ligato/vpp-agent/plugins/linuxv2/nsplugin/linuxcalls"
+)
+
+const (
+	// watcherInitialBackoff is used after a subscription attempt fails.
+	watcherInitialBackoff = time.Second
+	// watcherMaxBackoff caps the reconnect backoff for a single namespace.
+	watcherMaxBackoff = 30 * time.Second
+)
+
+// errNotFound is returned when the interface metadata could not be looked up
+// when (re)opening a watch subscription.
+var errNotFound = errors.New("interface metadata not found")
+
+// RouteWatcher subscribes to netlink route/neighbor change notifications inside
+// every namespace that hosts an agent-managed interface, forwarding add/del
+// events to the KVScheduler so that externally-installed or externally-deleted
+// routes and neighbors are detected without waiting for the next periodic Dump.
+//
+// It is an addition on top of the pull-only Dump used by RouteDescriptor and
+// NeighborDescriptor; when Disabled is set, no sockets are opened and dumps
+// remain the only source of truth (useful on constrained environments where an
+// extra goroutine+socket per namespace is not affordable).
+type RouteWatcher struct {
+	log       logging.Logger
+	ifPlugin  ifplugin.API
+	nsPlugin  nsplugin.API
+	scheduler notifier
+
+	// Disabled turns the watcher into a no-op; periodic Dump remains the only
+	// mechanism to detect drift.
+	Disabled bool
+
+	mu      sync.Mutex
+	watched map[string]chan struct{} // interface name -> stop channel
+}
+
+// notifier is the subset of KVScheduler used by the watcher - kept narrow so
+// that tests can supply a fake without pulling in the whole scheduler API.
+type notifier interface {
+	PushSBNotification(key string, value interface{}, metadata interface{}) error
+}
+
+// NewRouteWatcher creates a new instance of the route/neighbor watcher and, unless
+// disabled, starts following interface add/remove events so that a namespace
+// watch is kept alive for exactly the interfaces the agent currently manages.
+func NewRouteWatcher(ifPlugin ifplugin.API, nsPlugin nsplugin.API, scheduler notifier, log logging.PluginLogger, disabled bool) *RouteWatcher {
+	w := &RouteWatcher{
+		log:       log.NewLogger("route-watcher"),
+		ifPlugin:  ifPlugin,
+		nsPlugin:  nsPlugin,
+		scheduler: scheduler,
+		Disabled:  disabled,
+		watched:   make(map[string]chan struct{}),
+	}
+	if !disabled {
+		ifIdxEvents := make(chan ifaceidx.LinuxIfIndexDto, 100)
+		ifPlugin.GetInterfaceIndex().WatchInterfaces("route-watcher", ifIdxEvents)
+		go w.watchInterfaceEvents(ifIdxEvents)
+	}
+	return w
+}
+
+// watchInterfaceEvents starts/stops the per-namespace netlink watch as
+// interfaces known to the agent come and go.
+func (w *RouteWatcher) watchInterfaceEvents(events <-chan ifaceidx.LinuxIfIndexDto) {
+	for ev := range events {
+		if ev.Del {
+			w.UnwatchInterface(ev.Name)
+		} else {
+			w.WatchInterface(ev.Name)
+		}
+	}
+}
+
+// WatchInterface starts watching the namespace of the given interface for
+// out-of-band route/neighbor changes. It is a no-op if the watcher is disabled
+// or the interface is already watched. NewRouteWatcher subscribes to
+// ifPlugin.GetInterfaceIndex() add/delete notifications and calls
+// WatchInterface/UnwatchInterface accordingly, keeping one watch goroutine
+// alive per managed interface for the lifetime of that interface.
+func (w *RouteWatcher) WatchInterface(ifName string) {
+	if w.Disabled {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, watched := w.watched[ifName]; watched {
+		return
+	}
+	stopCh := make(chan struct{})
+	w.watched[ifName] = stopCh
+	go w.watchLoop(ifName, stopCh)
+}
+
+// UnwatchInterface stops watching the namespace of the given (removed) interface.
+func (w *RouteWatcher) UnwatchInterface(ifName string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	stopCh, watched := w.watched[ifName]
+	if !watched {
+		return
+	}
+	delete(w.watched, ifName)
+	close(stopCh)
+}
+
+// watchLoop keeps a netlink subscription for one interface's namespace alive,
+// reconnecting with exponential backoff whenever the socket is lost (e.g. the
+// namespace was removed and later re-created under the same interface name).
+func (w *RouteWatcher) watchLoop(ifName string, stopCh chan struct{}) {
+	backoff := watcherInitialBackoff
+	for {
+		err := w.subscribeOnce(ifName, stopCh)
+		if err == nil {
+			return // stopCh was closed
+		}
+		w.log.WithFields(logging.Fields{
+			"interface": ifName,
+			"err":       err,
+		}).Warn("Route/neighbor watch subscription failed, retrying")
+
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > watcherMaxBackoff {
+			backoff = watcherMaxBackoff
+		}
+	}
+}
+
+// subscribeOnce opens route+neighbor subscription sockets inside the interface's
+// namespace and forwards events until the socket errors out or stopCh closes.
+// A nil error means stopCh was closed (graceful shutdown); any other return
+// value is a reason to reconnect.
+func (w *RouteWatcher) subscribeOnce(ifName string, stopCh chan struct{}) error {
+	ifMeta, found := w.ifPlugin.GetInterfaceIndex().LookupByName(ifName)
+	if !found || ifMeta == nil {
+		return errNotFound
+	}
+
+	nsCtx := nslinuxcalls.NewNamespaceMgmtCtx()
+	revertNs, err := w.nsPlugin.SwitchToNamespace(nsCtx, ifMeta.Namespace)
+	if err != nil {
+		return err
+	}
+
+	// routeErrCh/neighErrCh are buffered so that ErrorCallback - invoked from
+	// netlink's internal goroutine - never blocks trying to deliver an error
+	// after this select loop has already returned for the other reason (stopCh
+	// closed, or the sibling channel errored first); an unbuffered channel
+	// would leak that goroutine (and its socket) forever in that case.
+	routeUpdCh := make(chan netlink.RouteUpdate)
+	routeErrCh := make(chan error, 1)
+	if err := netlink.RouteSubscribeWithOptions(routeUpdCh, stopCh, netlink.RouteSubscribeOptions{
+		ErrorCallback: func(err error) {
+			select {
+			case routeErrCh <- err:
+			default:
+			}
+		},
+	}); err != nil {
+		revertNs()
+		return err
+	}
+
+	neighUpdCh := make(chan netlink.NeighUpdate)
+	neighErrCh := make(chan error, 1)
+	if err := netlink.NeighSubscribeWithOptions(neighUpdCh, stopCh, netlink.NeighSubscribeOptions{
+		ErrorCallback: func(err error) {
+			select {
+			case neighErrCh <- err:
+			default:
+			}
+		},
+	}); err != nil {
+		revertNs()
+		return err
+	}
+	revertNs() // the sockets stay open in ifMeta's namespace regardless of our current ns
+
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case upd := <-routeUpdCh:
+			w.notifyRoute(ifName, upd)
+		case upd := <-neighUpdCh:
+			w.notifyNeighbor(ifName, upd)
+		case err := <-routeErrCh:
+			return err
+		case err := <-neighErrCh:
+			return err
+		}
+	}
+}
+
+// notifyRoute reports an externally observed route change to the KVScheduler.
+func (w *RouteWatcher) notifyRoute(ifName string, upd netlink.RouteUpdate) {
+	if upd.Route.Dst == nil {
+		return
+	}
+	// the table id must be part of the key, same as RouteDescriptor.Dump - a
+	// non-main-table route notified under the table-oblivious key would be
+	// unable to correlate with its NB/dumped counterpart, and would collide
+	// with a main-table route sharing the same destination/interface
+	key := l3.StaticRouteTableKey(upd.Route.Dst.String(), ifName, uint32(upd.Route.Table))
+	var value interface{}
+	if upd.Type != unix.RTM_DELROUTE {
+		scope, err := rtScopeFromNetlinkToNB(upd.Route.Scope)
+		if err != nil {
+			return // not an agent-manageable scope
+		}
+		var gwAddr string
+		if len(upd.Route.Gw) != 0 {
+			gwAddr = upd.Route.Gw.String()
+		}
+		value = &l3.StaticRoute{
+			OutgoingInterface: ifName,
+			Scope:             scope,
+			DstNetwork:        upd.Route.Dst.String(),
+			GwAddr:            gwAddr,
+			Metric:            uint32(upd.Route.Priority),
+			RouteTable:        uint32(upd.Route.Table),
+		}
+	}
+	if err := w.scheduler.PushSBNotification(key, value, nil); err != nil {
+		w.log.WithField("key", key).Warn("Failed to push route notification: ", err)
+	}
+}
+
+// notifyNeighbor reports an externally observed neighbor change to the KVScheduler.
+func (w *RouteWatcher) notifyNeighbor(ifName string, upd netlink.NeighUpdate) {
+	key := l3.NeighborKey(ifName, upd.Neigh.IP.String())
+	var value interface{}
+	if upd.Type != unix.RTM_DELNEIGH {
+		state, known := netlinkStateToNeighbor[upd.Neigh.State]
+		if !known {
+			return
+		}
+		value = &l3.LinuxNeighbor{
+			OutgoingInterface: ifName,
+			IpAddress:         upd.Neigh.IP.String(),
+			HwAddress:         upd.Neigh.HardwareAddr.String(),
+			State:             state,
+			Proxy:             upd.Neigh.Flags&netlink.NTF_PROXY != 0,
+		}
+	}
+	if err := w.scheduler.PushSBNotification(key, value, nil); err != nil {
+		w.log.WithField("key", key).Warn("Failed to push neighbor notification: ", err)
+	}
+}
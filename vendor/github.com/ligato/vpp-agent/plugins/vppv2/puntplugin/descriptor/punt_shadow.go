@@ -0,0 +1,114 @@
+// Copyright (c) 2018 Cisco and/or its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at:
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package descriptor
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/ligato/cn-infra/db/keyval"
+	"github.com/ligato/vpp-agent/plugins/vppv2/model/punt"
+)
+
+// puntShadowPrefix is the key prefix under which every successfully
+// Add-ed/Registered punt is persisted, so that Dump (and Delete, for punt
+// types the VPP API cannot read back) have something to work from.
+const puntShadowPrefix = "vpp/punt-to-host/shadow/"
+
+// puntShadow is a small persisted store of every punt-to-host entry the agent
+// has successfully programmed into VPP. It exists because the VPP binary API
+// only supports readback for socket registrations, not for plain punt-to-host
+// entries - without it, Dump can't reconstruct the NB view and Delete can't
+// even tell whether there is anything to undo.
+type puntShadow struct {
+	mu     sync.Mutex
+	broker keyval.ProtoBroker
+	cache  map[string]*punt.ToHost
+}
+
+// newPuntShadow creates a new shadow store backed by the given proto broker
+// (the same KVDB the agent already uses for its own internal state).
+func newPuntShadow(broker keyval.ProtoBroker) *puntShadow {
+	return &puntShadow{
+		broker: broker,
+		cache:  make(map[string]*punt.ToHost),
+	}
+}
+
+// shadowKey identifies a shadow entry by the tuple the NB model itself keys on.
+func shadowKey(p *punt.ToHost) string {
+	return fmt.Sprintf("%s%d/%d/%d", puntShadowPrefix, p.L3Protocol, p.L4Protocol, p.Port)
+}
+
+// Save records a successfully applied punt configuration.
+func (s *puntShadow) Save(p *punt.ToHost) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := shadowKey(p)
+	if err := s.broker.Put(key, p); err != nil {
+		return err
+	}
+	s.cache[key] = p
+	return nil
+}
+
+// Delete removes the shadow entry for the given punt configuration, returning
+// whether an entry actually existed.
+func (s *puntShadow) Delete(p *punt.ToHost) (existed bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := shadowKey(p)
+	if _, found := s.cache[key]; found {
+		existed = true
+	}
+	existedInKVDB, err := s.broker.Delete(key)
+	delete(s.cache, key)
+	return existed || existedInKVDB, err
+}
+
+// List returns every punt configuration currently recorded in the shadow store.
+func (s *puntShadow) List() []*punt.ToHost {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var all []*punt.ToHost
+	for _, p := range s.cache {
+		all = append(all, p)
+	}
+	return all
+}
+
+// Load populates the in-memory cache from the KVDB - called once on startup
+// by ReconcileOnStart, before it compares the cache against whatever VPP can
+// report.
+func (s *puntShadow) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, err := s.broker.ListValues(puntShadowPrefix)
+	if err != nil {
+		return err
+	}
+	for {
+		kv, stop := it.GetNext()
+		if stop {
+			break
+		}
+		p := &punt.ToHost{}
+		if err := kv.GetValue(p); err != nil {
+			return err
+		}
+		s.cache[kv.GetKey()] = p
+	}
+	return nil
+}
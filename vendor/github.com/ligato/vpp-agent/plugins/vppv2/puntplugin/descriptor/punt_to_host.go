@@ -18,7 +18,9 @@ import (
 	"errors"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/ligato/cn-infra/db/keyval"
 	"github.com/ligato/cn-infra/logging"
+	scheduler "github.com/ligato/vpp-agent/plugins/kvscheduler/api"
 	"github.com/ligato/vpp-agent/plugins/vppv2/model/punt"
 	"github.com/ligato/vpp-agent/plugins/vppv2/puntplugin/descriptor/adapter"
 	"github.com/ligato/vpp-agent/plugins/vppv2/puntplugin/vppcalls"
@@ -46,13 +48,18 @@ type PuntToHostDescriptor struct {
 	// dependencies
 	log         logging.Logger
 	puntHandler vppcalls.PuntVppAPI
+
+	// shadow records every successfully applied punt so that Dump and Delete
+	// keep working for the non-socket punts the VPP API cannot read back.
+	shadow *puntShadow
 }
 
 // NewPuntToHostDescriptor creates a new instance of the punt to host descriptor.
-func NewPuntToHostDescriptor(puntHandler vppcalls.PuntVppAPI, log logging.LoggerFactory) *PuntToHostDescriptor {
+func NewPuntToHostDescriptor(puntHandler vppcalls.PuntVppAPI, kvStore keyval.ProtoBroker, log logging.LoggerFactory) *PuntToHostDescriptor {
 	return &PuntToHostDescriptor{
 		log:         log.NewLogger("punt-to-host-descriptor"),
 		puntHandler: puntHandler,
+		shadow:      newPuntShadow(kvStore),
 	}
 }
 
@@ -67,6 +74,7 @@ func (d *PuntToHostDescriptor) GetDescriptor() *adapter.PuntToHostDescriptor {
 		NBKeyPrefix:        punt.PrefixToHost,
 		Add:                d.Add,
 		Delete:             d.Delete,
+		Modify:             d.Modify,
 		ModifyWithRecreate: d.ModifyWithRecreate,
 		IsRetriableFailure: d.IsRetriableFailure,
 		Dump:               d.Dump,
@@ -96,26 +104,37 @@ func (d *PuntToHostDescriptor) Add(key string, punt *punt.ToHost) (metadata inte
 
 	// add punt to host
 	if punt.SocketPath == "" {
-		err = d.puntHandler.AddPunt(punt)
-		if err != nil {
+		if err = d.puntHandler.AddPunt(punt); err != nil {
 			d.log.Error(err)
+			return nil, err
 		}
-		return nil, err
+		if err = d.shadow.Save(punt); err != nil {
+			d.log.Error(err)
+		}
+		return nil, nil
 	}
 
 	// register punt to socket
-	err = d.puntHandler.RegisterPuntSocket(punt)
-	if err != nil {
+	if err = d.puntHandler.RegisterPuntSocket(punt); err != nil {
 		d.log.Error(err)
+		return nil, err
 	}
-	return nil, err
+	if err = d.shadow.Save(punt); err != nil {
+		d.log.Error(err)
+	}
+	return nil, nil
 }
 
 // Delete removes VPP punt configuration.
 func (d *PuntToHostDescriptor) Delete(key string, punt *punt.ToHost, metadata interface{}) error {
 	if punt.SocketPath == "" {
-		// TODO punt delete does not work for non-socket
-		d.log.Warn("Punt delete is not supported by the VPP")
+		// the VPP API still cannot undo a plain punt-to-host registration, but we
+		// can at least stop telling Dump that it is still there
+		if _, err := d.shadow.Delete(punt); err != nil {
+			d.log.Error(err)
+			return err
+		}
+		d.log.Warn("Punt delete is not supported by the VPP, removed from shadow state only")
 		return nil
 	}
 
@@ -123,20 +142,109 @@ func (d *PuntToHostDescriptor) Delete(key string, punt *punt.ToHost, metadata in
 	err := d.puntHandler.DeregisterPuntSocket(punt)
 	if err != nil {
 		d.log.Error(err)
+		return err
+	}
+	if _, err := d.shadow.Delete(punt); err != nil {
+		d.log.Error(err)
 	}
-	return err
+	return nil
 }
 
-// Dump returns all configured VPP punt to host entries.
+// Modify changes an existing socket registration without a full recreate when
+// only the SocketPath differs - the rest of the tuple (L3/L4 protocol, port)
+// is the key, so changing it is handled by ModifyWithRecreate instead.
+func (d *PuntToHostDescriptor) Modify(key string, oldPunt, newPunt *punt.ToHost, oldMetadata interface{}) (newMetadata interface{}, err error) {
+	if oldPunt.SocketPath == "" || newPunt.SocketPath == "" {
+		// one side is a plain punt-to-host, which has no update primitive - recreate
+		return d.recreate(oldPunt, newPunt)
+	}
+	if err := d.puntHandler.DeregisterPuntSocket(oldPunt); err != nil {
+		d.log.Error(err)
+		return nil, err
+	}
+	if err := d.puntHandler.RegisterPuntSocket(newPunt); err != nil {
+		d.log.Error(err)
+		return nil, err
+	}
+	if err := d.shadow.Save(newPunt); err != nil {
+		d.log.Error(err)
+	}
+	return nil, nil
+}
+
+// recreate re-applies a punt configuration via delete+add, used by Modify for
+// a plain punt-to-host, which has no update primitive (changes flagged by
+// ModifyWithRecreate never reach Modify).
+func (d *PuntToHostDescriptor) recreate(oldPunt, newPunt *punt.ToHost) (newMetadata interface{}, err error) {
+	if err := d.Delete("", oldPunt, nil); err != nil {
+		return nil, err
+	}
+	return d.Add("", newPunt)
+}
+
+// Dump returns all configured VPP punt to host entries, reconstructed from the
+// shadow store - the VPP binary API only supports readback for socket
+// registrations, so the shadow store is the only complete source of truth.
 func (d *PuntToHostDescriptor) Dump(correlate []adapter.PuntToHostKVWithMetadata) (dump []adapter.PuntToHostKVWithMetadata, err error) {
-	// TODO dump for punt and punt socket register missing in api
-	d.log.Warn("Dump punt/socket register is not supported by the VPP")
-	return []adapter.PuntToHostKVWithMetadata{}, nil
+	for _, p := range d.shadow.List() {
+		dump = append(dump, adapter.PuntToHostKVWithMetadata{
+			Key:    punt.ToHostKey(p.L3Protocol, p.L4Protocol, p.Port),
+			Value:  p,
+			// UnknownOrigin, not FromNB: this is reconstructed from our own
+			// shadow store, not read back from VPP, and may be stale (e.g. a
+			// socket ReconcileOnStart found deregistered behind our back) - the
+			// scheduler, not this descriptor, should decide if it's removable.
+			Origin: scheduler.UnknownOrigin,
+		})
+	}
+	return dump, nil
 }
 
-// ModifyWithRecreate always returns true - punt entries are always modified via re-creation.
+// ReconcileOnStart loads the persisted shadow state and, for the socket
+// registrations VPP can actually report, diffs it against what VPP has - any
+// mismatch (e.g. the agent crashed between Register and Save, or something
+// deregistered the socket behind the agent's back) is logged so it surfaces to
+// an operator rather than silently drifting.
+func (d *PuntToHostDescriptor) ReconcileOnStart() error {
+	if err := d.shadow.Load(); err != nil {
+		return err
+	}
+	vppSockets, err := d.puntHandler.DumpRegisteredPuntSockets()
+	if err != nil {
+		// socket dump is still not guaranteed to be supported by every VPP version
+		d.log.Warn("Failed to dump registered punt sockets for reconciliation: ", err)
+		return nil
+	}
+	vppByKey := make(map[string]*punt.ToHost, len(vppSockets))
+	for _, s := range vppSockets {
+		vppByKey[shadowKey(s)] = s
+	}
+	shadowByKey := make(map[string]struct{})
+	for _, shadowed := range d.shadow.List() {
+		shadowByKey[shadowKey(shadowed)] = struct{}{}
+		if shadowed.SocketPath == "" {
+			continue // not dumpable by VPP, nothing to compare against
+		}
+		if _, found := vppByKey[shadowKey(shadowed)]; !found {
+			d.log.WithField("punt", shadowed).Warn("Shadow state references a punt socket no longer registered in VPP")
+		}
+	}
+	for key, registered := range vppByKey {
+		if _, found := shadowByKey[key]; !found {
+			d.log.WithField("punt", registered).Warn("Punt socket registered in VPP is missing from shadow state")
+		}
+	}
+	return nil
+}
+
+// ModifyWithRecreate returns true whenever the L3/L4 protocol or port (the
+// identifying tuple) changes, or when switching between a plain punt-to-host
+// and a socket registration - everything else goes through Modify instead.
 func (d *PuntToHostDescriptor) ModifyWithRecreate(key string, oldPunt, newPunt *punt.ToHost, metadata interface{}) bool {
-	return true
+	return oldPunt.L3Protocol != newPunt.L3Protocol ||
+		oldPunt.L4Protocol != newPunt.L4Protocol ||
+		oldPunt.Port != newPunt.Port ||
+		(oldPunt.SocketPath == "") != (newPunt.SocketPath == "")
 }
 
 // IsRetriableFailure returns <false> for errors related to invalid configuration.